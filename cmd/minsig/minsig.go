@@ -10,113 +10,46 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"flag"
 	"log"
 	"net/http"
 	"os"
-	"sync"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
-type sessiondesc struct {
-	Type string `json:"type"`
-	SDP  string `json:"sdp"`
-}
-
-type session struct {
-	offer  *sessiondesc
-	answer *sessiondesc
-	c      *sync.Cond
-}
-
-var slots = struct {
-	m map[string]*session
-	sync.RWMutex
-}{m: make(map[string]*session)}
-
+// serveHTTP serves the index page. The actual signalling now happens over
+// the /s/ WebSocket endpoint (see ws.go); the single-shot POST API this
+// used to implement couldn't trickle ICE candidates and has been retired.
 func serveHTTP(w http.ResponseWriter, r *http.Request) {
-	slotkey := r.URL.Path
-
-	if r.Method == http.MethodGet && slotkey == "/" {
-		w.Write([]byte(indexpage))
-		return
-	}
-
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if r.Method != http.MethodPost {
-		http.Error(w, "invalid method", 400)
-	}
-
-	enc := json.NewEncoder(w)
-	dec := json.NewDecoder(r.Body)
-	var msg sessiondesc
-	err := dec.Decode(&msg)
-	if err != nil {
-		log.Printf("%v", err)
+	if r.Method != http.MethodGet || r.URL.Path != "/" {
+		http.NotFound(w, r)
 		return
 	}
-
-	log.Printf("%v: post", slotkey)
-
-	slots.Lock()
-	slot := slots.m[slotkey]
-	if slot == nil {
-		if msg.Type != "offer" {
-			slots.Unlock()
-			log.Printf("%v: [1] not an offer", slotkey)
-			http.Error(w, "invalid offer description", 400)
-			return
-		}
-
-		// New offer (probably)
-		slot = &session{
-			offer: &msg,
-			c:     sync.NewCond(&sync.Mutex{}),
-		}
-		slot.c.L.Lock()
-		slots.m[slotkey] = slot
-		slots.Unlock()
-
-		for slot.answer == nil {
-			slot.c.Wait()
-		}
-
-		err := enc.Encode(slot.answer)
-		slot.c.L.Unlock()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-
-		slots.Lock()
-		delete(slots.m, slotkey)
-		slots.Unlock()
-	} else {
-		slots.Unlock()
-		if msg.Type == "offer" {
-			// Already have offer, pass that down
-			err := enc.Encode(slot.offer)
-			if err != nil {
-				log.Printf("%v", err)
-				return
-			}
-		} else if msg.Type == "answer" {
-			// This is an answer to an offer, wake the other go routines up.
-			slot.answer = &msg
-			slot.c.Broadcast()
-		}
-	}
+	w.Write([]byte(indexpage))
 }
 
 func main() {
 	httpaddr := flag.String("http", ":http", "http listen address")
 	httpsaddr := flag.String("https", ":https", "https listen address")
 	secretpath := flag.String("secrets", os.Getenv("HOME")+"/keys", "path to put let's encrypt cache")
+	turnsecret := flag.String("turn-secret", "", "shared secret for minting TURN REST API credentials (disabled if empty)")
+	turnttl := flag.Duration("turn-ttl", time.Hour, "lifetime of minted TURN credentials")
+	var turnuris turnURIList
+	flag.Var(&turnuris, "turn-uri", "TURN server URI to hand out with credentials (repeatable)")
+	storedsn := flag.String("store", "", "slot storage backend: empty for in-process, or redis://host:port for Redis")
 	flag.Parse()
 
+	turnCfg = turnConfig{secret: *turnsecret, uris: turnuris, ttl: *turnttl}
+
+	s, err := newStore(*storedsn)
+	if err != nil {
+		log.Fatalf("store: %v", err)
+	}
+	store = s
+
 	m := &autocert.Manager{
 		Cache:  autocert.DirCache(*secretpath),
 		Prompt: autocert.AcceptTOS,
@@ -128,15 +61,20 @@ func main() {
 		},
 	}
 
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveHTTP)
+	mux.HandleFunc("/s/", serveWS)
+	mux.HandleFunc("/turn-credentials", serveTURN)
+
 	srv := &http.Server{
 		Addr:    *httpaddr,
-		Handler: m.HTTPHandler(http.HandlerFunc(serveHTTP)),
+		Handler: m.HTTPHandler(mux),
 	}
 	go func() { log.Fatal(srv.ListenAndServe()) }()
 
 	ssrv := &http.Server{
 		Addr:      *httpsaddr,
-		Handler:   http.HandlerFunc(serveHTTP),
+		Handler:   mux,
 		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
 	}
 	log.Fatal(ssrv.ListenAndServeTLS("", ""))
@@ -201,31 +139,31 @@ footer {
 
 <h2>MODEL</h2>
 
-<p>WebRTC uses an "offer" and "answer" model, where one party puts sends an "offer" encoded in a JSON object and the other party responds similarly with an "answer" JSON object. Minimum signal uses a slot system to allow clients to exchange offers and answers.</p>
+<p>WebRTC uses an "offer" and "answer" model, where one party sends an "offer" encoded in a JSON object and the other party responds similarly with an "answer" JSON object, and both trickle "candidate" objects as ICE gathering discovers them. Minimum signal uses a slot system to allow clients to exchange these messages.</p>
 
-<p>Slots are arbitrary strings, currently capped at 255 bytes. If Alice wants to reach Bob, then they or their user agents perform the following steps:</p>
+<p>Slots are arbitrary strings. If Alice wants to reach Bob, then they or their user agents perform the following steps:</p>
 
 <ol>
-<li>A uploads its offer object to Minimum Signal at some arbitrary slot.</li>
+<li>A opens a WebSocket to a slot of its choosing (or lets the server pick one).</li>
 <li>A communicates the slot name to B out of band. E.g. message, AirDrop, email, or shout it out across the room.</li>
-<li>B fetches A's offer and uploads its own.
-<li>A receives B's offer and they both carry on the WebRTC nogotiations directly.
+<li>B opens a WebSocket to the same slot.
+<li>A and B send each other "offer"/"answer"/"candidate" messages over their sockets as WebRTC negotiation proceeds, and carry on directly once connected.
 </ol>
 
-<p>At this point, Minimum Signal's role is finished and the slot is free to be used by someone else. This slot model is similar to what the non-crypto parts of <a href="https://github.com/warner/magic-wormhole">Magic Wormhole</a> use.</p>
+<p>At this point, Minimum Signal's role is finished; closing either socket frees the slot for someone else. This slot model is similar to what the non-crypto parts of <a href="https://github.com/warner/magic-wormhole">Magic Wormhole</a> use.</p>
 
 <h2>API</h2>
 
 <p>There is only one endpoint supported:</p>
-<pre>https://minimumsignal.0f.io/$slot</pre>
-<p>where $slot is the slot name.</p>
-<p>There is only one method supported, POST with the SDP as body.</p>
-<p>If the SDP is of type "offer" and the slot is free, the request will block until someone uploads an answer to the same slot, at which point it will return the answer.
-<p>If the SDP is of type "offer" and the slot is busy, the response will be the original offer.
-<p>If the SDP is of type "answer", it will be forwarded to the original sender of the offer (who up until this point has been blocked).
-<p>All other requests are invalid.</p>
+<pre>wss://minimumsignal.0f.io/s/$slot</pre>
+<p>where $slot is the slot name. Connecting to <code>/s/</code> with no slot name allocates one and returns it in a <code>new_slot</code> frame; connecting with an existing slot name joins the peer already waiting there.</p>
+<p>Once both peers have joined, the server relays every frame one side sends to the other, in order, for as long as both sockets stay open &mdash; including frames sent before the second peer arrived. The server never inspects frame contents, so peers are free to use whatever message shapes suit them (plain JSON, as in the example below, or an encrypted blob from the <code>wormhole</code> Go package).</p>
 
-<p>The intended usage is that both parties, A and B, race to upload their offers to the same slot. Whichever of them loses has to accept the other one's offer and upload an answer based on it.
+<p>When the server operator has configured a TURN secret, every <code>new_slot</code> and <code>joined</code> frame is also accompanied by a freshly minted set of TURN credentials, for peers stuck behind a symmetric NAT that can't complete ICE peer to peer. Credentials can also be fetched on their own from:</p>
+<pre>https://minimumsignal.0f.io/turn-credentials</pre>
+<p>This only works if the server operator has configured a TURN secret; otherwise this endpoint 404s.</p>
+
+<p>Where slot state itself lives is an operator concern, not part of the API: a single instance keeps it in memory by default, but passing <code>-store redis://...</code> moves it to Redis so the two peers on a slot can land on different instances behind a load balancer.</p>
 
 <h2>SECURITY CONSIDIRATIONS</h2>
 
@@ -237,7 +175,7 @@ footer {
 
 <h2>USAGE EXAMPLE</h2>
 
-<p>Here's some example JavaScript to demostrate the usage of the API. The dial() function returns an RTCPeerConnection object.</p>
+<p>Here's some example JavaScript to demostrate the usage of the API. Candidates trickle over the socket as they're discovered, instead of waiting for gathering to finish before the offer or answer is sent, so connections typically complete in well under a second. The dial() function returns an RTCPeerConnection object.</p>
 
 <pre>
 // initconn initialises a peer connection by adding streams or data channels.
@@ -246,45 +184,50 @@ let initconn = pc => {
 }
 
 let dial = async (slot, config) => {
-	let pc = new RTCPeerConnection(config);
+	let ws = new WebSocket(`+"`wss://minimumsignal.0f.io/s/${slot}`"+`)
+	await new Promise(r=>{ws.onopen=r})
+
+	// The first message is always new_slot or joined, depending on whether
+	// we let the server pick the slot name or named it ourselves, but
+	// either way it carries a fresh set of TURN credentials for us to use
+	// if the server has a TURN secret configured.
+	let hello = await new Promise(r=>{ws.onmessage=e=>r(JSON.parse(e.data))})
+	if (hello.iceServers) {
+		config = {...config, iceServers: [...(config.iceServers||[]), hello.iceServers]}
+	}
 
+	let pc = new RTCPeerConnection(config);
 	initconn(pc);
 
-	// Create an offer.
-	await pc.setLocalDescription(await pc.createOffer())
+	// Trickle our candidates out as they're found.
+	pc.onicecandidate = e => {
+		if (e.candidate) {
+			ws.send(JSON.stringify({type: "candidate", candidate: e.candidate}))
+		}
+	}
 
-	// Wait for ICE candidates.
-	await new Promise(r=>{pc.onicecandidate=e=>{if(e.candidate === null){r()}}})
-
-	// Upload offer.
-	let response = await fetch(`+"`https://minimumsignal.0f.io/${slot}`"+`, {
-		method: 'POST',
-		body: JSON.stringify(pc.localDescription)
-	})
-	let remote = await response.json();
-
-	if (remote["type"] === "offer") {
-		// We got back another offer, which means someone else (possibly
-		// the party we're trying to reach) beat us to this slot.
-
-		// Throw away our offer and accept this one, creating an answer.
-		pc = new RTCPeerConnection(config);
-		initconn(pc);
-		// await pc.setLocalDescription({"type":"rollback"});
-		await pc.setRemoteDescription(new RTCSessionDescription(remote));
-		await pc.setLocalDescription(await pc.createAnswer());
-
-		// Wait for ICE candidates.
-		await new Promise(r=>{pc.onicecandidate=e=>{if(e.candidate === null){r()}}})
-
-		// Upload answer.
-		await fetch(`+"`https://minimumsignal.0f.io/${slot}`"+`, {
-			method: 'POST',
-			body: JSON.stringify(pc.localDescription)
-		})
-	} else if (remote["type"] === "answer") {
-		// We got back an answer to our offer. Accept it.
-		await pc.setRemoteDescription(new RTCSessionDescription(remote));
+	// Whoever's message the server delivers first is the offerer; the
+	// other side answers. We race by sending our offer immediately and
+	// reacting to whatever arrives first.
+	await pc.setLocalDescription(await pc.createOffer())
+	ws.send(JSON.stringify(pc.localDescription))
+
+	ws.onmessage = async e => {
+		let msg = JSON.parse(e.data)
+		switch (msg.type) {
+		case "offer":
+			// Someone else beat us to this slot; answer their offer instead.
+			await pc.setRemoteDescription(msg)
+			await pc.setLocalDescription(await pc.createAnswer())
+			ws.send(JSON.stringify(pc.localDescription))
+			break
+		case "answer":
+			await pc.setRemoteDescription(msg)
+			break
+		case "candidate":
+			await pc.addIceCandidate(msg.candidate)
+			break
+		}
 	}
 
 	// We're done.
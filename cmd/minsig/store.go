@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SlotStore brokers the two-sided frame relay for a slot (see ws.go).
+// Implementations must let the two peers join from different processes,
+// so the service can run behind a load balancer with any instance able
+// to broker any slot.
+type SlotStore interface {
+	// Join assigns the caller one of the two sides of key, creating the
+	// slot if it doesn't exist yet, and arranges for it to expire after
+	// ttl if it's never fully joined or used again. It returns
+	// ErrSlotFull if key already has two peers.
+	Join(ctx context.Context, key string, ttl time.Duration) (side int, err error)
+	// Send delivers msg, sent by side, to whichever peer is listening on
+	// the other side of key.
+	Send(ctx context.Context, key string, side int, msg []byte) error
+	// Recv blocks until a frame sent by the peer opposite side arrives,
+	// ctx is done, or that peer leaves.
+	Recv(ctx context.Context, key string, side int) ([]byte, error)
+	// Leave releases whatever resources this side held open on key.
+	Leave(ctx context.Context, key string, side int) error
+}
+
+// ErrSlotFull is returned by SlotStore.Join when key already has two
+// peers.
+var ErrSlotFull = errors.New("slot full")
+
+// ErrPeerLeft is returned by SlotStore.Recv when the peer opposite side
+// has left without anything left to deliver.
+var ErrPeerLeft = errors.New("peer left")
+
+// store is the active slot backend, chosen with the -store flag. It
+// defaults to the in-process store so tests and single-instance
+// deployments don't need anything else configured.
+var store SlotStore = newMemStore()
+
+// newStore builds the SlotStore named by dsn: "" or "mem://" for the
+// in-process store, "redis://..." for the Redis-backed one.
+func newStore(dsn string) (SlotStore, error) {
+	if dsn == "" {
+		return newMemStore(), nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	switch u.Scheme {
+	case "mem":
+		return newMemStore(), nil
+	case "redis", "rediss":
+		return newRedisStore(dsn)
+	default:
+		return nil, fmt.Errorf("store: unknown scheme %q", u.Scheme)
+	}
+}
+
+// wsslot is a two-sided pipe between the peers sharing a slot. Frames
+// written by one side are queued for the other, so a peer that joins
+// late, or is momentarily slow to read, still sees every frame in the
+// order it was sent. It backs memStore, the in-process SlotStore.
+type wsslot struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	n      int
+	queue  [2][][]byte // queue[i]: frames sent by peer i, awaiting peer i^1
+	closed [2]bool     // closed[i]: peer i has gone away
+}
+
+func newWSSlot() *wsslot {
+	s := &wsslot{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// join assigns the caller one of the two sides of the slot. ok is false
+// if the slot already has two peers.
+func (s *wsslot) join() (side int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n >= 2 {
+		return 0, false
+	}
+	side = s.n
+	s.n++
+	return side, true
+}
+
+// send queues msg, written by peer side, for delivery to the other peer.
+func (s *wsslot) send(side int, msg []byte) {
+	s.mu.Lock()
+	s.queue[side] = append(s.queue[side], msg)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// recv blocks until a frame from the peer opposite side becomes
+// available, that peer closes, or ctx is done.
+func (s *wsslot) recv(ctx context.Context, side int) (msg []byte, ok bool) {
+	peer := side ^ 1
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue[peer]) == 0 && !s.closed[peer] && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	if len(s.queue[peer]) == 0 {
+		return nil, false
+	}
+	msg, s.queue[peer] = s.queue[peer][0], s.queue[peer][1:]
+	return msg, true
+}
+
+// close marks side as gone, waking the other peer if it's blocked in recv.
+func (s *wsslot) close(side int) {
+	s.mu.Lock()
+	s.closed[side] = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// memStore is the default, in-process SlotStore. Its Join ignores ttl:
+// a slot is removed only when one of its sides calls Leave.
+type memStore struct {
+	mu sync.Mutex
+	m  map[string]*wsslot
+}
+
+func newMemStore() *memStore {
+	return &memStore{m: make(map[string]*wsslot)}
+}
+
+func (st *memStore) Join(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	st.mu.Lock()
+	s, ok := st.m[key]
+	if !ok {
+		s = newWSSlot()
+		st.m[key] = s
+	}
+	st.mu.Unlock()
+
+	side, ok := s.join()
+	if !ok {
+		return 0, ErrSlotFull
+	}
+	return side, nil
+}
+
+func (st *memStore) Send(ctx context.Context, key string, side int, msg []byte) error {
+	st.mu.Lock()
+	s := st.m[key]
+	st.mu.Unlock()
+	if s == nil {
+		return fmt.Errorf("store: unknown slot %q", key)
+	}
+	s.send(side, msg)
+	return nil
+}
+
+func (st *memStore) Recv(ctx context.Context, key string, side int) ([]byte, error) {
+	st.mu.Lock()
+	s := st.m[key]
+	st.mu.Unlock()
+	if s == nil {
+		return nil, fmt.Errorf("store: unknown slot %q", key)
+	}
+	msg, ok := s.recv(ctx, side)
+	if ok {
+		return msg, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrPeerLeft
+}
+
+func (st *memStore) Leave(ctx context.Context, key string, side int) error {
+	st.mu.Lock()
+	s, ok := st.m[key]
+	if ok {
+		delete(st.m, key) // either side leaving ends the slot's usefulness
+	}
+	st.mu.Unlock()
+	if s != nil {
+		s.close(side)
+	}
+	return nil
+}
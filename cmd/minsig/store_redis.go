@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStore is a SlotStore backed by Redis, so any number of minsig
+// instances behind a load balancer can broker any slot: SET NX PX claims
+// each side of the slot, and a list per side (RPUSH/BLPOP) carries its
+// frames, so a peer that hasn't connected yet still sees everything sent
+// before it joins once it starts popping. A side leaving pushes a
+// sentinel onto its own close list, which wakes a peer blocked in Recv
+// immediately, the same way memStore's wsslot.close does. EXPIRE on both
+// the claim and the queue cleans up slots nobody ever finished.
+type redisStore struct {
+	rdb *redis.Client
+}
+
+func newRedisStore(dsn string) (*redisStore, error) {
+	opt, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("redis store: %w", err)
+	}
+	return &redisStore{rdb: redis.NewClient(opt)}, nil
+}
+
+func (st *redisStore) claimKey(key string, side int) string {
+	return fmt.Sprintf("wh:%s:claim:%d", key, side)
+}
+
+func (st *redisStore) queueKey(key string, side int) string {
+	return fmt.Sprintf("wh:%s:queue:%d", key, side)
+}
+
+// closeKey holds a single sentinel element once side has left, so a peer
+// blocked in Recv on BLPOP wakes immediately instead of only noticing via
+// its own ctx expiring. A plain DEL of the queue doesn't wake a blocked
+// BLPOP at all.
+func (st *redisStore) closeKey(key string, side int) string {
+	return fmt.Sprintf("wh:%s:closed:%d", key, side)
+}
+
+func (st *redisStore) Join(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	for side := 0; side < 2; side++ {
+		claimed, err := st.rdb.SetNX(ctx, st.claimKey(key, side), 1, ttl).Result()
+		if err != nil {
+			return 0, err
+		}
+		if claimed {
+			return side, nil
+		}
+	}
+	return 0, ErrSlotFull
+}
+
+func (st *redisStore) Send(ctx context.Context, key string, side int, msg []byte) error {
+	qkey := st.queueKey(key, side)
+	if err := st.rdb.RPush(ctx, qkey, msg).Err(); err != nil {
+		return err
+	}
+	return st.rdb.Expire(ctx, qkey, wsSlotLifetime).Err()
+}
+
+// recvPollInterval bounds how long a single BLPOP waits before Recv
+// rechecks ctx. It needs to be short: BLPOP only notices ctx
+// cancellation once it returns, not while it's blocked.
+const recvPollInterval = 1 * time.Second
+
+func (st *redisStore) Recv(ctx context.Context, key string, side int) ([]byte, error) {
+	peer := side ^ 1
+	dataKey := st.queueKey(key, peer)
+	closeKey := st.closeKey(key, peer)
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		res, err := st.rdb.BLPop(ctx, recvPollInterval, dataKey, closeKey).Result()
+		switch {
+		case err == redis.Nil:
+			continue // BLPOP just timed out; keep polling until ctx says otherwise
+		case err != nil:
+			return nil, err
+		}
+		if res[0] == closeKey {
+			return nil, ErrPeerLeft
+		}
+		return []byte(res[1]), nil
+	}
+}
+
+func (st *redisStore) Leave(ctx context.Context, key string, side int) error {
+	closeKey := st.closeKey(key, side)
+	if err := st.rdb.RPush(ctx, closeKey, 1).Err(); err != nil {
+		return err
+	}
+	if err := st.rdb.Expire(ctx, closeKey, wsIdleTimeout).Err(); err != nil {
+		return err
+	}
+	return st.rdb.Del(ctx,
+		st.claimKey(key, 0), st.claimKey(key, 1),
+		st.queueKey(key, 0), st.queueKey(key, 1),
+	).Err()
+}
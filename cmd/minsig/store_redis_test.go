@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T) *redisStore {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+	return &redisStore{rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+}
+
+func TestRedisStoreJoinFull(t *testing.T) {
+	st := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if side, err := st.Join(ctx, "slot", time.Minute); err != nil || side != 0 {
+		t.Fatalf("Join() = %v, %v, want 0, nil", side, err)
+	}
+	if side, err := st.Join(ctx, "slot", time.Minute); err != nil || side != 1 {
+		t.Fatalf("Join() = %v, %v, want 1, nil", side, err)
+	}
+	if _, err := st.Join(ctx, "slot", time.Minute); !errors.Is(err, ErrSlotFull) {
+		t.Fatalf("Join() err = %v, want ErrSlotFull", err)
+	}
+}
+
+func TestRedisStoreSendRecvOrdering(t *testing.T) {
+	st := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, err := st.Join(ctx, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Join(ctx, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Side 0 sends before side 1 ever calls Recv, to cover late-joiner
+	// buffering as well as ordering.
+	if err := st.Send(ctx, "slot", 0, []byte("offer")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Send(ctx, "slot", 0, []byte("candidate")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"offer", "candidate"} {
+		got, err := st.Recv(ctx, "slot", 1)
+		if err != nil || string(got) != want {
+			t.Fatalf("Recv() = %q, %v, want %q, nil", got, err, want)
+		}
+	}
+}
+
+func TestRedisStoreLeaveWakesRecv(t *testing.T) {
+	st := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, err := st.Join(ctx, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Join(ctx, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		msg []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		msg, err := st.Recv(ctx, "slot", 1)
+		done <- result{msg, err}
+	}()
+
+	time.Sleep(50 * time.Millisecond) // give Recv a chance to start blocking
+	if err := st.Leave(ctx, "slot", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-done:
+		if !errors.Is(r.err, ErrPeerLeft) {
+			t.Fatalf("Recv() err = %v, want ErrPeerLeft", r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Recv() did not unblock promptly after the peer called Leave")
+	}
+}
+
+func TestRedisStoreRecvCtxCancel(t *testing.T) {
+	st := newTestRedisStore(t)
+	bg := context.Background()
+
+	if _, err := st.Join(bg, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Join(bg, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(bg)
+	done := make(chan error, 1)
+	go func() {
+		_, err := st.Recv(ctx, "slot", 1)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) // give Recv a chance to start blocking
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Recv() err = %v, want context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("Recv() took %v to notice ctx cancellation, want well under wsIdleTimeout", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Recv() did not unblock after ctx was canceled")
+	}
+}
+
+func TestRedisStoreLeaveFreesSlot(t *testing.T) {
+	st := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, err := st.Join(ctx, "slot", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Leave(ctx, "slot", 0); err != nil {
+		t.Fatal(err)
+	}
+	if side, err := st.Join(ctx, "slot", time.Minute); err != nil || side != 0 {
+		t.Fatalf("Join() after Leave = %v, %v, want 0, nil", side, err)
+	}
+}
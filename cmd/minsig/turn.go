@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// turnConfig holds the shared secret and parameters used to mint
+// short-lived TURN REST API credentials, following the scheme coturn's
+// use-auth-secret mode expects. It is set once in main and only read
+// afterwards, so it needs no locking.
+type turnConfig struct {
+	secret string
+	uris   []string
+	ttl    time.Duration
+}
+
+var turnCfg turnConfig
+
+// turnURIList collects repeated -turn-uri flags into a slice.
+type turnURIList []string
+
+func (l *turnURIList) String() string { return strings.Join(*l, ",") }
+func (l *turnURIList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// iceServers is the TURN REST API credential response: a username and
+// password good for ttl seconds, plus the TURN server URIs they apply to.
+type iceServers struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"`
+	URIs     []string `json:"uris"`
+}
+
+// turnCredentials mints a credential scoped to name (typically a slot
+// key) that expires after cfg.ttl. It returns nil if no -turn-secret was
+// configured.
+func turnCredentials(cfg turnConfig, name string) *iceServers {
+	if cfg.secret == "" {
+		return nil
+	}
+	username := fmt.Sprintf("%d:%s", time.Now().Add(cfg.ttl).Unix(), name)
+	mac := hmac.New(sha1.New, []byte(cfg.secret))
+	mac.Write([]byte(username))
+	return &iceServers{
+		Username: username,
+		Password: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		TTL:      int(cfg.ttl.Seconds()),
+		URIs:     cfg.uris,
+	}
+}
+
+// serveTURN implements GET /turn-credentials, for clients that want ICE
+// server credentials without going through a slot exchange. The optional
+// "slot" query parameter is folded into the credential's username.
+func serveTURN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", 400)
+		return
+	}
+	ice := turnCredentials(turnCfg, r.URL.Query().Get("slot"))
+	if ice == nil {
+		http.Error(w, "turn credentials not configured", 404)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(ice); err != nil {
+		log.Printf("turn-credentials: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The /s/ endpoint is a long-lived, bidirectional relay: once both peers
+// on a slot have joined, every frame one side writes is delivered to the
+// other, in order, for as long as both sockets stay open. This lets peers
+// trickle ICE candidates as they're discovered instead of waiting for
+// gathering to finish before exchanging a single SDP, and lets PAKE-
+// secured callers (see the wormhole package) exchange arbitrary sealed
+// frames. The server never inspects frame contents.
+//
+// Where the slot itself actually lives is up to the configured SlotStore
+// (see store.go); this file only speaks the WebSocket protocol.
+const (
+	wsMaxFrameSize = 16 << 10 // plenty for a trickled candidate or a sealed SDP
+	wsIdleTimeout  = 30 * time.Second
+	wsSlotLifetime = 5 * time.Minute
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  wsMaxFrameSize,
+	WriteBufferSize: wsMaxFrameSize,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// slotFrame is the first message sent down a freshly upgraded /s/
+// connection: new_slot when the server picked the slot name (the caller
+// connected to /s/ with none), joined when the caller named the slot
+// itself. Either way it carries a fresh set of TURN credentials, when a
+// TURN secret is configured, since both sides may need them to traverse
+// a symmetric NAT.
+type slotFrame struct {
+	Type       string      `json:"type"`
+	Slot       string      `json:"slot,omitempty"`
+	ICEServers *iceServers `json:"iceServers,omitempty"`
+}
+
+var nextSlotID uint64
+
+// newSlotID hands out short, unique slot names for callers who don't pick
+// their own, since they haven't had a chance to agree on one out of band.
+func newSlotID() string {
+	return strconv.FormatUint(atomic.AddUint64(&nextSlotID, 1), 36)
+}
+
+// serveWS implements the /s/{slot} endpoint described above. Connecting
+// with no slot allocates one and returns it in a new_slot frame;
+// connecting with an existing slot joins the peer waiting there.
+func serveWS(w http.ResponseWriter, r *http.Request) {
+	slotkey := strings.TrimPrefix(r.URL.Path, "/s/")
+	freshSlot := slotkey == ""
+	if freshSlot {
+		slotkey = newSlotID()
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("ws upgrade: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsMaxFrameSize)
+
+	ctx, cancel := context.WithTimeout(r.Context(), wsSlotLifetime)
+	defer cancel()
+
+	side, err := store.Join(ctx, slotkey, wsSlotLifetime)
+	if err != nil {
+		reason := "join failed"
+		if errors.Is(err, ErrSlotFull) {
+			reason = "slot full"
+		} else {
+			log.Printf("%v: %v", slotkey, err)
+		}
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason))
+		return
+	}
+	defer store.Leave(context.Background(), slotkey, side)
+
+	frame := slotFrame{Type: "joined", ICEServers: turnCredentials(turnCfg, slotkey)}
+	if freshSlot {
+		frame.Type = "new_slot"
+		frame.Slot = slotkey
+	}
+	if err := conn.WriteJSON(frame); err != nil {
+		log.Printf("%v: %v", slotkey, err)
+		return
+	}
+	log.Printf("%v: peer %d joined", slotkey, side)
+
+	relayWS(ctx, conn, side, slotkey)
+}
+
+// relayWS pumps frames between conn and the slot's store entry until ctx
+// is done or either side closes. Reading and waiting for the peer happen
+// concurrently, so a burst of outgoing frames (e.g. trickled ICE
+// candidates) never waits on the peer to catch up on reads, and our own
+// disconnect is noticed immediately instead of only once the peer sends
+// something.
+func relayWS(ctx context.Context, conn *websocket.Conn, side int, slotkey string) {
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if err := store.Send(ctx, slotkey, side, msg); err != nil {
+				log.Printf("%v: %v", slotkey, err)
+				return
+			}
+		}
+	}()
+
+	peerMsg := make(chan []byte)
+	peerErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			msg, err := store.Recv(ctx, slotkey, side)
+			if err != nil {
+				peerErr <- err
+				return
+			}
+			select {
+			case peerMsg <- msg:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-peerMsg:
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				log.Printf("%v: %v", slotkey, err)
+				return
+			}
+		case err := <-peerErr:
+			if !errors.Is(err, ErrPeerLeft) && ctx.Err() == nil {
+				log.Printf("%v: %v", slotkey, err)
+			}
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "peer left"))
+			return
+		case err := <-readErr:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("%v: %v", slotkey, err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
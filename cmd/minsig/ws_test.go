@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSSlotOrdering(t *testing.T) {
+	s := newWSSlot()
+	side, ok := s.join()
+	if !ok || side != 0 {
+		t.Fatalf("join() = %v, %v, want 0, true", side, ok)
+	}
+
+	want := [][]byte{[]byte("offer"), []byte("candidate 1"), []byte("candidate 2")}
+	for _, msg := range want {
+		s.send(0, msg)
+	}
+
+	for _, w := range want {
+		got, ok := s.recv(context.Background(), 1)
+		if !ok || string(got) != string(w) {
+			t.Fatalf("recv() = %q, %v, want %q, true", got, ok, w)
+		}
+	}
+}
+
+func TestWSSlotLateJoinerBuffering(t *testing.T) {
+	s := newWSSlot()
+	side, ok := s.join()
+	if !ok || side != 0 {
+		t.Fatalf("join() = %v, %v, want 0, true", side, ok)
+	}
+
+	// Peer 0 sends before peer 1 has even connected.
+	s.send(0, []byte("offer"))
+	s.send(0, []byte("candidate"))
+
+	side, ok = s.join()
+	if !ok || side != 1 {
+		t.Fatalf("join() = %v, %v, want 1, true", side, ok)
+	}
+
+	got, ok := s.recv(context.Background(), 1)
+	if !ok || string(got) != "offer" {
+		t.Fatalf("recv() = %q, %v, want %q, true", got, ok, "offer")
+	}
+	got, ok = s.recv(context.Background(), 1)
+	if !ok || string(got) != "candidate" {
+		t.Fatalf("recv() = %q, %v, want %q, true", got, ok, "candidate")
+	}
+}
+
+func TestServeWSSlotGC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(serveWS))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/s/gc-test"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+
+	mem, ok := store.(*memStore)
+	if !ok {
+		t.Fatalf("store is a %T, want *memStore", store)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mem.mu.Lock()
+		_, exists := mem.m["gc-test"]
+		mem.mu.Unlock()
+		if !exists {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("slot was not garbage collected after the lone peer disconnected")
+}
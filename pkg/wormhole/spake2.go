@@ -0,0 +1,31 @@
+package wormhole
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+)
+
+// spake2Curve and the M, N base points below implement SPAKE2 (RFC 9382)
+// over NIST P-256. M and N are fixed, public constants specified by the
+// RFC; they are not secret and must be the same on both ends of the
+// handshake, which is why spakeExchange (see wormhole.go) hard-codes them
+// rather than deriving them.
+var spake2Curve = elliptic.P256()
+
+var (
+	spake2Mx, spake2My = mustDecodePoint("02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f")
+	spake2Nx, spake2Ny = mustDecodePoint("03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49")
+)
+
+func mustDecodePoint(h string) (x, y *big.Int) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		panic("wormhole: bad SPAKE2 constant: " + err.Error())
+	}
+	x, y = elliptic.UnmarshalCompressed(spake2Curve, b)
+	if x == nil {
+		panic("wormhole: invalid SPAKE2 constant")
+	}
+	return x, y
+}
@@ -0,0 +1,313 @@
+// Package wormhole establishes authenticated, end-to-end encrypted WebRTC
+// connections brokered by an untrusted signalling server (see cmd/minsig).
+//
+// Two peers who share a low-entropy passphrase run SPAKE2 over the
+// server's /s/ WebSocket relay to derive a symmetric key, then use that
+// key to seal their SDP offer and answer before sending them. The
+// signalling server only ever sees opaque ciphertext, so it cannot read
+// or tamper with the negotiation.
+package wormhole
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Dial allocates a new slot on the signalling server at signalURL and
+// waits for a peer to call Accept with the same slot and pass. Once the
+// slot name is known, but before the (potentially slow) key exchange and
+// negotiation begin, onSlot is called with it, so the caller can display
+// it to the user right away; onSlot may be nil. Dial returns once the
+// PAKE and the SDP exchange have completed; it does not wait for ICE
+// connectivity checks, so the returned connection may not be connected
+// yet.
+func Dial(ctx context.Context, signalURL, pass string, config webrtc.Configuration, onSlot func(slot string)) (*webrtc.PeerConnection, error) {
+	conn, slot, turnServer, err := dialSlot(ctx, signalURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("wormhole: dial: %w", err)
+	}
+	defer conn.Close()
+	if onSlot != nil {
+		onSlot(slot)
+	}
+	if turnServer != nil {
+		config.ICEServers = append(config.ICEServers, *turnServer)
+	}
+
+	key, err := spakeExchange(conn, []byte(pass), spakeRoleA)
+	if err != nil {
+		return nil, fmt.Errorf("wormhole: key exchange: %w", err)
+	}
+	return negotiate(conn, key, config, true)
+}
+
+// Accept joins the slot previously allocated by Dial and completes the
+// handshake from the other side.
+func Accept(ctx context.Context, signalURL, slot, pass string, config webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	conn, _, turnServer, err := dialSlot(ctx, signalURL, slot)
+	if err != nil {
+		return nil, fmt.Errorf("wormhole: dial: %w", err)
+	}
+	defer conn.Close()
+	if turnServer != nil {
+		config.ICEServers = append(config.ICEServers, *turnServer)
+	}
+
+	key, err := spakeExchange(conn, []byte(pass), spakeRoleB)
+	if err != nil {
+		return nil, fmt.Errorf("wormhole: key exchange: %w", err)
+	}
+	return negotiate(conn, key, config, false)
+}
+
+// turnCredentials mirrors the iceServers frame cmd/minsig's /s/ endpoint
+// sends on join (see turn.go's iceServers), so callers get the TURN
+// relay it minted for this slot without needing to fetch it separately.
+type turnCredentials struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"`
+	URIs     []string `json:"uris"`
+}
+
+// dialSlot opens the WebSocket connection to the given slot, or to a
+// freshly allocated one if slot is empty, and returns the connection,
+// the slot name in use, and the TURN server the signalling server
+// minted for it, if any.
+func dialSlot(ctx context.Context, signalURL, slot string) (*websocket.Conn, string, *webrtc.ICEServer, error) {
+	u, err := url.Parse(signalURL)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	u.Path = "/s/" + slot
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var frame struct {
+		Type       string           `json:"type"`
+		Slot       string           `json:"slot"`
+		ICEServers *turnCredentials `json:"iceServers"`
+	}
+	if err := conn.ReadJSON(&frame); err != nil {
+		conn.Close()
+		return nil, "", nil, err
+	}
+	if slot == "" {
+		if frame.Type != "new_slot" {
+			conn.Close()
+			return nil, "", nil, fmt.Errorf("wormhole: unexpected frame %q", frame.Type)
+		}
+		slot = frame.Slot
+	}
+
+	var turnServer *webrtc.ICEServer
+	if frame.ICEServers != nil {
+		turnServer = &webrtc.ICEServer{
+			URLs:       frame.ICEServers.URIs,
+			Username:   frame.ICEServers.Username,
+			Credential: frame.ICEServers.Password,
+		}
+	}
+	return conn, slot, turnServer, nil
+}
+
+// negotiate performs the SDP offer/answer exchange over conn, sealing
+// every message with key before it touches the wire, and returns the
+// resulting peer connection once ICE gathering completes.
+func negotiate(conn *websocket.Conn, key [32]byte, config webrtc.Configuration, offerer bool) (*webrtc.PeerConnection, error) {
+	pc, err := webrtc.NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !offerer {
+		var offer webrtc.SessionDescription
+		if err := recvSealed(conn, key, &offer); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+		if err := pc.SetLocalDescription(answer); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		<-webrtc.GatheringCompletePromise(pc)
+		if err := sendSealed(conn, key, pc.LocalDescription()); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		return pc, nil
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	<-webrtc.GatheringCompletePromise(pc)
+	if err := sendSealed(conn, key, pc.LocalDescription()); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	var answer webrtc.SessionDescription
+	if err := recvSealed(conn, key, &answer); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+func sendSealed(conn *websocket.Conn, key [32]byte, v interface{}) error {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	sealed := secretbox.Seal(nonce[:], plain, &nonce, &key)
+	return conn.WriteMessage(websocket.BinaryMessage, sealed)
+}
+
+func recvSealed(conn *websocket.Conn, key [32]byte, v interface{}) error {
+	_, sealed, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if len(sealed) < 24 {
+		return fmt.Errorf("wormhole: short message")
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	plain, ok := secretbox.Open(nil, sealed[24:], &nonce, &key)
+	if !ok {
+		return fmt.Errorf("wormhole: decryption failed")
+	}
+	return json.Unmarshal(plain, v)
+}
+
+// spakeRole picks which of the two fixed curve points (see spake2.go) a
+// peer blinds its share with. The two sides of a handshake must use
+// opposite roles; Dial is always the A side, Accept the B side.
+type spakeRole int
+
+const (
+	spakeRoleA spakeRole = iota
+	spakeRoleB
+)
+
+// spakeExchange runs SPAKE2 over conn and returns the derived 32 byte
+// symmetric key. Both peers must call it with the same pass and with
+// opposite roles.
+func spakeExchange(conn *websocket.Conn, pass []byte, role spakeRole) ([32]byte, error) {
+	var key [32]byte
+
+	curve := spake2Curve
+	params := curve.Params()
+
+	w := new(big.Int).SetBytes(hashToScalar(pass))
+	w.Mod(w, params.N)
+
+	mineX, mineY, theirsX, theirsY := spake2Mx, spake2My, spake2Nx, spake2Ny
+	if role == spakeRoleB {
+		mineX, mineY, theirsX, theirsY = spake2Nx, spake2Ny, spake2Mx, spake2My
+	}
+
+	x, err := rand.Int(rand.Reader, params.N)
+	if err != nil {
+		return key, err
+	}
+
+	gx, gy := curve.ScalarBaseMult(x.Bytes())
+	bx, by := curve.ScalarMult(mineX, mineY, w.Bytes())
+	Xx, Xy := curve.Add(gx, gy, bx, by)
+	if Xx.Sign() == 0 && Xy.Sign() == 0 {
+		return key, errors.New("wormhole: degenerate SPAKE2 share")
+	}
+	ours := elliptic.MarshalCompressed(curve, Xx, Xy)
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, ours); err != nil {
+		return key, err
+	}
+	_, theirs, err := conn.ReadMessage()
+	if err != nil {
+		return key, err
+	}
+	Yx, Yy := elliptic.UnmarshalCompressed(curve, theirs)
+	if Yx == nil {
+		return key, errors.New("wormhole: invalid peer SPAKE2 message")
+	}
+
+	// Unblind the peer's share: Y' = Y - w*theirs, then K = x*Y'.
+	nx, ny := curve.ScalarMult(theirsX, theirsY, w.Bytes())
+	ny = negateMod(ny, params.P)
+	Ypx, Ypy := curve.Add(Yx, Yy, nx, ny)
+	Kx, Ky := curve.ScalarMult(Ypx, Ypy, x.Bytes())
+	if Kx.Sign() == 0 && Ky.Sign() == 0 {
+		return key, errors.New("wormhole: degenerate SPAKE2 shared point")
+	}
+
+	// The transcript must be identical on both ends, so it's ordered by
+	// role (A's share first, then B's) rather than by "ours"/"theirs",
+	// which flips between the two sides.
+	aShare, bShare := ours, theirs
+	if role == spakeRoleB {
+		aShare, bShare = theirs, ours
+	}
+
+	h := sha256.New()
+	h.Write(pass)
+	h.Write(aShare)
+	h.Write(bShare)
+	h.Write(Kx.Bytes())
+	h.Write(Ky.Bytes())
+	copy(key[:], h.Sum(nil))
+	return key, nil
+}
+
+func hashToScalar(pass []byte) []byte {
+	sum := sha256.Sum256(pass)
+	return sum[:]
+}
+
+func negateMod(y, p *big.Int) *big.Int {
+	neg := new(big.Int).Sub(p, y)
+	return neg.Mod(neg, p)
+}
@@ -0,0 +1,203 @@
+package wormhole
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// testRelay is a minimal stand-in for cmd/minsig's /s/ endpoint: it
+// allocates a slot on first connection (replying with new_slot), joins a
+// second connection to it (replying with joined), and then pumps frames
+// between the two blindly, exactly as serveWS does. It exists because
+// pkg/wormhole can't import cmd/minsig (an unrelated main package) to
+// reuse its handler directly.
+type testRelay struct {
+	upgrader websocket.Upgrader
+
+	mu   sync.Mutex
+	slot map[string]chan *websocket.Conn
+	next uint64
+}
+
+func newTestRelay() *testRelay {
+	return &testRelay{slot: make(map[string]chan *websocket.Conn)}
+}
+
+func (r *testRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/s/")
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	freshSlot := key == ""
+	if freshSlot {
+		key = strconv.FormatUint(atomic.AddUint64(&r.next, 1), 36)
+	}
+
+	r.mu.Lock()
+	ch, ok := r.slot[key]
+	if !ok {
+		ch = make(chan *websocket.Conn, 1)
+		r.slot[key] = ch
+	}
+	r.mu.Unlock()
+
+	if freshSlot {
+		conn.WriteJSON(map[string]string{"type": "new_slot", "slot": key})
+		peer := <-ch
+		relayTestConns(conn, peer)
+		return
+	}
+
+	// The underlying connection was hijacked by Upgrade above, so it
+	// stays open once this handler returns; the allocating side's
+	// relayTestConns goroutine takes it from here.
+	conn.WriteJSON(map[string]string{"type": "joined"})
+	ch <- conn
+}
+
+// relayTestConns pumps frames in both directions until either side
+// closes.
+func relayTestConns(a, b *websocket.Conn) {
+	done := make(chan struct{}, 2)
+	pump := func(from, to *websocket.Conn) {
+		defer func() { done <- struct{}{} }()
+		for {
+			mt, msg, err := from.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := to.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}
+	go pump(a, b)
+	go pump(b, a)
+	<-done
+}
+
+// TestSpakeExchangeAgrees drives spakeExchange directly over a real
+// websocket pair (no WebRTC/ICE involved) and checks both roles land on
+// the same key. This is the one guaranteed to notice a transcript
+// mismatch: TestDialAccept only gets this far once ICE gathering has
+// also completed, which can hang in network-constrained environments before
+// the decrypt path is ever exercised.
+func TestSpakeExchangeAgrees(t *testing.T) {
+	var mux http.ServeMux
+	done := make(chan struct{})
+	var upgrader websocket.Upgrader
+	var keyB [32]byte
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		key, err := spakeExchange(conn, []byte("correct horse battery staple"), spakeRoleB)
+		if err != nil {
+			t.Errorf("spakeExchange(B) = %v", err)
+			return
+		}
+		keyB = key
+		close(done)
+	})
+	srv := httptest.NewServer(&mux)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	keyA, err := spakeExchange(conn, []byte("correct horse battery staple"), spakeRoleA)
+	if err != nil {
+		t.Fatalf("spakeExchange(A) = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server-side spakeExchange never finished")
+	}
+
+	if keyA != keyB {
+		t.Fatalf("keyA = %x, keyB = %x, want equal", keyA, keyB)
+	}
+}
+
+func TestDialAccept(t *testing.T) {
+	relay := newTestRelay()
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+	signalURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	config := webrtc.Configuration{}
+
+	slotCh := make(chan string, 1)
+	dialErr := make(chan error, 1)
+	var dialed *webrtc.PeerConnection
+	go func() {
+		pc, err := Dial(context.Background(), signalURL, "correct horse battery staple", config, func(slot string) {
+			slotCh <- slot
+		})
+		dialed = pc
+		dialErr <- err
+	}()
+
+	var slot string
+	select {
+	case slot = <-slotCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial never reported a slot")
+	}
+
+	acceptErr := make(chan error, 1)
+	var accepted *webrtc.PeerConnection
+	go func() {
+		pc, err := Accept(context.Background(), signalURL, slot, "correct horse battery staple", config)
+		accepted = pc
+		acceptErr <- err
+	}()
+
+	if err := <-dialErr; err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	if err := <-acceptErr; err != nil {
+		t.Fatalf("Accept() = %v", err)
+	}
+	defer dialed.Close()
+	defer accepted.Close()
+
+	for _, pc := range []*webrtc.PeerConnection{dialed, accepted} {
+		waitConnected(t, pc)
+	}
+}
+
+// waitConnected polls pc's connection state until it reaches Connected or
+// the test times out, since pion doesn't expose a blocking wait for it.
+func waitConnected(t *testing.T, pc *webrtc.PeerConnection) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("peer connection never reached Connected, stuck at %v", pc.ConnectionState())
+}